@@ -16,12 +16,14 @@ package v1
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"sort"
+	"strings"
+	"text/template"
 
 	"github.com/tektoncd/pipeline/pkg/apis/config"
-	"golang.org/x/exp/maps"
 	"k8s.io/apimachinery/pkg/util/sets"
-	"k8s.io/utils/strings/slices"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"knative.dev/pkg/apis"
 )
 
@@ -35,17 +37,26 @@ type Matrix struct {
 	Params Params `json:"params,omitempty"`
 
 	// Include is a list of IncludeParams which allows passing in specific combinations of Parameters into the Matrix.
-	// Note that Include is in preview mode and not yet supported.
 	// +optional
 	// +listType=atomic
 	Include IncludeParamsList `json:"include,omitempty"`
+
+	// Exclude is a list of ExcludeParams which allows excluding specific combinations of Parameters from the Matrix.
+	// +optional
+	// +listType=atomic
+	Exclude ExcludeParamsList `json:"exclude,omitempty"`
+
+	// NameTemplate is a Go template used to derive a stable, user-visible name for each combination
+	// generated from Params, e.g. "{{.os}}-go{{.go}}". It is executed against the combination's Params,
+	// keyed by Param name. Combinations added via Include are named by IncludeParams.Name instead.
+	// +optional
+	NameTemplate string `json:"nameTemplate,omitempty"`
 }
 
 // IncludeParamsList is a list of IncludeParams which allows passing in specific combinations of Parameters into the Matrix.
 type IncludeParamsList []IncludeParams
 
 // IncludeParams allows passing in a specific combinations of Parameters into the Matrix.
-// Note this struct is in preview mode and not yet supported
 type IncludeParams struct {
 	// Name the specified combination
 	Name string `json:"name,omitempty"`
@@ -56,19 +67,160 @@ type IncludeParams struct {
 	Params Params `json:"params,omitempty"`
 }
 
+// ExcludeParamsList is a list of ExcludeParams which allows excluding specific combinations of Parameters from the Matrix.
+type ExcludeParamsList []ExcludeParams
+
+// ExcludeParams allows excluding a specific combination of Parameters from the Matrix.
+type ExcludeParams struct {
+	// Params takes only `Parameters` of type `"string"`
+	// The names of the `params` must match the names of the `params` in the Matrix `Params`
+	// +listType=atomic
+	Params Params `json:"params,omitempty"`
+}
+
 // Combination is a map, mainly defined to hold a single combination from a Matrix with key as param.Name and value as param.Value
 type Combination map[string]string
 
 // Combinations is a Combination list
 type Combinations []Combination
 
-// FanOut returns an list of params that represent combinations
+// FanOut returns an list of params that represent combinations. It is a thin wrapper around FanOutIter
+// for callers that need the full, materialized list of combinations; FanOutIter should be preferred when
+// the number of combinations may be large, since FanOut holds every combination in memory at once.
+//
+// FanOut does not resolve references to a previous PipelineTask's results (see HasResultRefs): callers
+// must not invoke FanOut on a Matrix for which HasResultRefs returns true until that resolution has
+// happened and the referenced results have been substituted into Params, or FanOut will hand out the
+// literal, unresolved reference string as a combination's Param value.
 func (m *Matrix) FanOut() []Params {
-	var combinations Combinations
-	for _, parameter := range m.Params {
-		combinations = combinations.fanOutMatrixParams(parameter)
+	var combinations []Params
+	// f never returns an error, so FanOutIter itself can never return one here.
+	_ = m.FanOutIter(func(params Params) error {
+		combinations = append(combinations, params)
+		return nil
+	})
+	return combinations
+}
+
+// FanOutIter emits the Matrix's combinations one at a time by calling f, instead of materializing the
+// full cartesian product in memory. Combinations generated from Params are produced using a mixed-radix
+// counter over each Param's array values (the index i_k for Param k is incremented like an odometer,
+// carrying into i_(k+1) on overflow), filtered through Exclude, followed by the combinations added via
+// Include. It returns the first error returned by f, stopping early without calling f again.
+func (m *Matrix) FanOutIter(f func(Params) error) error {
+	var rangeErr error
+	m.rangeGeneratedCombinations(func(combination Combination) bool {
+		if combination.matchesAnyExclude(m.Exclude) {
+			return true
+		}
+		if err := f(Combinations{combination}.toParams()[0]); err != nil {
+			rangeErr = err
+			return false
+		}
+		return true
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+	for _, include := range m.Include {
+		if err := f(include.Params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rangeGeneratedCombinations calls f once for each Combination generated from Params, using a mixed-radix
+// counter over each Param's array values (the index i_k for Param k is incremented like an odometer,
+// carrying into i_(k+1) on overflow) so the full cartesian product is never held in memory at once. The
+// first Param is the fastest-changing, matching the order FanOut has always produced. It stops as soon as
+// f returns false.
+func (m *Matrix) rangeGeneratedCombinations(f func(Combination) bool) {
+	lengths := make([]int, len(m.Params))
+	total := 1
+	for i, param := range m.Params {
+		lengths[i] = len(param.Value.ArrayVal)
+		total *= lengths[i]
+	}
+	if len(m.Params) == 0 {
+		total = 0
+	}
+	indices := make([]int, len(m.Params))
+	for n := 0; n < total; n++ {
+		combination := make(Combination, len(m.Params))
+		for i, param := range m.Params {
+			combination[param.Name] = param.Value.ArrayVal[indices[i]]
+		}
+		if !f(combination) {
+			return
+		}
+		// Increment the mixed-radix counter like an odometer, carrying into the next digit on overflow.
+		for i := 0; i < len(indices); i++ {
+			indices[i]++
+			if indices[i] < lengths[i] {
+				break
+			}
+			indices[i] = 0
+		}
 	}
-	return combinations.toParams()
+}
+
+// NamedCombination pairs a single fanned-out Combination with a stable, user-visible Name that the
+// pipeline reconciler can use when naming the child TaskRun for that combination.
+type NamedCombination struct {
+	// Name is the combination's user-visible name: derived from Matrix.NameTemplate for a combination
+	// generated from Params, or from IncludeParams.Name for a combination added via Include. It is empty
+	// when NameTemplate is unset and the combination was not added via Include.
+	Name string
+
+	// Params is the combination's Params, in the same format returned by FanOut.
+	Params Params
+}
+
+// FanOutNamed returns the same combinations as FanOutIter, each paired with a Name: combinations generated
+// from Params are named by executing NameTemplate against the combination, and combinations added via
+// Include are named by the corresponding IncludeParams.Name. It walks combinations via
+// rangeGeneratedCombinations and, like FanOut, returns them as one slice, so every resulting
+// NamedCombination is held in memory at once.
+func (m *Matrix) FanOutNamed() ([]NamedCombination, error) {
+	var named []NamedCombination
+	var rangeErr error
+	m.rangeGeneratedCombinations(func(combination Combination) bool {
+		if combination.matchesAnyExclude(m.Exclude) {
+			return true
+		}
+		name, err := combination.executeNameTemplate(m.NameTemplate)
+		if err != nil {
+			rangeErr = err
+			return false
+		}
+		named = append(named, NamedCombination{Name: name, Params: Combinations{combination}.toParams()[0]})
+		return true
+	})
+	if rangeErr != nil {
+		return nil, rangeErr
+	}
+	for _, include := range m.Include {
+		named = append(named, NamedCombination{Name: include.Name, Params: include.Params})
+	}
+	return named, nil
+}
+
+// executeNameTemplate renders nameTemplate against the Combination's Params, keyed by Param name.
+// It returns the empty string when nameTemplate is empty.
+func (c Combination) executeNameTemplate(nameTemplate string) (string, error) {
+	if nameTemplate == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("matrixCombinationName").Parse(nameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("matrix.nameTemplate %q is not a valid template: %w", nameTemplate, err)
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, map[string]string(c)); err != nil {
+		return "", fmt.Errorf("matrix.nameTemplate %q could not be executed against combination %v: %w", nameTemplate, c, err)
+	}
+	return rendered.String(), nil
 }
 
 // toParams transforms Combinations from a slice of map[string]string to a slice of Params
@@ -90,36 +242,37 @@ func (cs Combinations) toParams() []Params {
 	return listOfParams
 }
 
-// fanOutMatrixParams generates new combinations based on Matrix Parameters.
-func (cs Combinations) fanOutMatrixParams(param Param) Combinations {
-	if len(cs) == 0 {
-		return initializeCombinations(param)
+// applyExclude filters out any Combination that matches one of the given ExcludeParamsList entries.
+// A Combination matches an ExcludeParams entry when every param in that entry is present in the
+// Combination with an equal value; an ExcludeParams entry need not reference every Matrix param.
+func (cs Combinations) applyExclude(excludes ExcludeParamsList) Combinations {
+	if len(excludes) == 0 {
+		return cs
 	}
-	return cs.distribute(param)
-}
-
-// distribute generates a new Combination of Parameters by adding a new Parameter to an existing list of Combinations.
-func (cs Combinations) distribute(param Param) Combinations {
-	var expandedCombinations Combinations
-	for _, value := range param.Value.ArrayVal {
-		for _, combination := range cs {
-			newCombination := make(Combination)
-			maps.Copy(newCombination, combination)
-			newCombination[param.Name] = value
-			_, orderedCombination := newCombination.sortCombination()
-			expandedCombinations = append(expandedCombinations, orderedCombination)
+	var filtered Combinations
+	for _, combination := range cs {
+		if !combination.matchesAnyExclude(excludes) {
+			filtered = append(filtered, combination)
 		}
 	}
-	return expandedCombinations
+	return filtered
 }
 
-// initializeCombinations generates a new Combination based on the first Parameter in the Matrix.
-func initializeCombinations(param Param) Combinations {
-	var combinations Combinations
-	for _, value := range param.Value.ArrayVal {
-		combinations = append(combinations, Combination{param.Name: value})
+// matchesAnyExclude returns true if the Combination matches any of the given ExcludeParamsList entries.
+func (c Combination) matchesAnyExclude(excludes ExcludeParamsList) bool {
+	for _, exclude := range excludes {
+		matches := true
+		for _, param := range exclude.Params {
+			if val, ok := c[param.Name]; !ok || val != param.Value.StringVal {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
 	}
-	return combinations
+	return false
 }
 
 // sortCombination sorts the given Combination based on the Parameter names to produce a deterministic ordering
@@ -138,54 +291,19 @@ func (c Combination) sortCombination() ([]string, Combination) {
 	return order, sortedCombination
 }
 
-// CountCombinations returns the count of Combinations of Parameters generated from the Matrix in PipelineTask.
+// CountCombinations returns the count of Combinations of Parameters generated from the Matrix in
+// PipelineTask. It mirrors exactly what FanOutIter emits: Combinations generated from Params that survive
+// Exclude, plus one for every Include entry, since Include entries are always emitted by FanOutIter
+// regardless of whether their values happen to coincide with an excluded or already-generated Combination.
 func (m *Matrix) CountCombinations() int {
-	// Iterate over Matrix Parameters and compute count of all generated Combinations
-	count := m.countGeneratedCombinationsFromParams()
-
-	// Add any additional Combinations generated from Matrix Include Parameters
-	count += m.countNewCombinationsFromInclude()
-
-	return count
-}
-
-// countGeneratedCombinationsFromParams returns the count of Combinations of Parameters generated from the Matrix
-// Parameters
-func (m *Matrix) countGeneratedCombinationsFromParams() int {
-	if !m.HasParams() {
-		return 0
-	}
-	count := 1
-	for _, param := range m.Params {
-		count *= len(param.Value.ArrayVal)
-	}
-	return count
-}
-
-// countNewCombinationsFromInclude returns the count of Combinations of Parameters generated from the Matrix
-// Include Parameters
-func (m *Matrix) countNewCombinationsFromInclude() int {
-	if !m.HasInclude() {
-		return 0
-	}
-	if !m.HasParams() {
-		return len(m.Include)
-	}
 	count := 0
-	matrixParamMap := m.Params.extractParamMapArrVals()
-	for _, include := range m.Include {
-		for _, param := range include.Params {
-			if val, exist := matrixParamMap[param.Name]; exist {
-				// If the Matrix Include param values does not exist, a new Combination will be generated
-				if !slices.Contains(val, param.Value.StringVal) {
-					count++
-				} else {
-					break
-				}
-			}
+	m.rangeGeneratedCombinations(func(combination Combination) bool {
+		if !combination.matchesAnyExclude(m.Exclude) {
+			count++
 		}
-	}
-	return count
+		return true
+	})
+	return count + len(m.Include)
 }
 
 // HasInclude returns true if the Matrix has Include Parameters
@@ -198,6 +316,42 @@ func (m *Matrix) HasParams() bool {
 	return m != nil && m.Params != nil && len(m.Params) > 0
 }
 
+// HasExclude returns true if the Matrix has Exclude Parameters
+func (m *Matrix) HasExclude() bool {
+	return m != nil && m.Exclude != nil && len(m.Exclude) > 0
+}
+
+// resultRefPattern matches a Matrix Param array element that consists of nothing but a reference to a
+// previous PipelineTask's results, e.g. "$(tasks.list-shards.results.shards[*])" or the indexed form
+// "$(tasks.list-shards.results.shards[0])". It is anchored to the whole element so that values which mix
+// a result reference with other content (e.g. another, possibly invalid, variable reference) still go
+// through the normal pipeline parameter variable validation.
+var resultRefPattern = regexp.MustCompile(`^\$\(tasks\.[\w.-]+\.results\.[\w.-]+(\[\*\]|\[[0-9]+\])?\)$`)
+
+// HasResultRefs returns true if any Matrix.Params value references the results of a previous
+// PipelineTask, e.g. "$(tasks.list-shards.results.shards[*])". Such Matrix Params cannot be fanned out
+// until the referenced PipelineTask completes and its results are available.
+//
+// NOTE: resolving such a reference (replacing it with the referenced results once they're available) and
+// fanning out afterwards is a reconciler-side responsibility that does not exist yet in this codebase;
+// today HasResultRefs only lets validation recognize and defer-skip these Matrix Params (see
+// validateCombinationsCount and validatePipelineParametersVariablesInMatrixParameters). Fanning out such a
+// Matrix before that resolution step exists is not supported: FanOut/FanOutIter will hand out the literal,
+// unresolved reference string as a combination's Param value instead of the referenced results.
+func (m *Matrix) HasResultRefs() bool {
+	if !m.HasParams() {
+		return false
+	}
+	for _, param := range m.Params {
+		for _, value := range param.Value.ArrayVal {
+			if resultRefPattern.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // GetAllParams returns a list of all Matrix Parameters
 func (m *Matrix) GetAllParams() Params {
 	var params Params
@@ -212,7 +366,19 @@ func (m *Matrix) GetAllParams() Params {
 	return params
 }
 
+// validateCombinationsCount enforces DefaultMaxMatrixCombinationsCount against CountCombinations.
+//
+// It intentionally skips that check when HasResultRefs is true: CountCombinations counts each result-ref
+// array as a single literal value, which bears no relation to the actual number of combinations the
+// referenced results will produce once resolved. Enforcing the cap against that placeholder count would
+// be meaningless (and could reject, or just as easily pass, an arbitrarily large Matrix for the wrong
+// reason). Real enforcement for such a Matrix requires deferring the check to a reconciler-side resolution
+// step that does not exist yet in this codebase; until then, this is deliberately unenforced rather than
+// silently checked against the wrong number.
 func (m *Matrix) validateCombinationsCount(ctx context.Context) (errs *apis.FieldError) {
+	if m.HasResultRefs() {
+		return errs
+	}
 	matrixCombinationsCount := m.CountCombinations()
 	maxMatrixCombinationsCount := config.FromContextOrDefaults(ctx).Defaults.DefaultMaxMatrixCombinationsCount
 	if matrixCombinationsCount > maxMatrixCombinationsCount {
@@ -221,6 +387,37 @@ func (m *Matrix) validateCombinationsCount(ctx context.Context) (errs *apis.Fiel
 	return errs
 }
 
+// validateCombinationNames validates that, once NameTemplate and Include[i].Name are taken into account,
+// every named combination produced by FanOutNamed has a unique, DNS-1123 label-safe Name. Combinations
+// left unnamed (NameTemplate unset and not added via Include) are not validated.
+//
+// Like validateCombinationsCount, it skips this check when HasResultRefs is true: FanOutNamed would
+// otherwise render the literal, unresolved reference string through NameTemplate and reject it as an
+// invalid label, rather than deferring to the (not yet implemented) reconciler-side resolution step.
+func (m *Matrix) validateCombinationNames() (errs *apis.FieldError) {
+	if m.HasResultRefs() {
+		return errs
+	}
+	named, err := m.FanOutNamed()
+	if err != nil {
+		return apis.ErrInvalidValue(err.Error(), "matrix.nameTemplate")
+	}
+	seen := sets.NewString()
+	for i, combination := range named {
+		if combination.Name == "" {
+			continue
+		}
+		if labelErrs := validation.IsDNS1123Label(combination.Name); len(labelErrs) > 0 {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("combination name %q is not a valid DNS-1123 label: %s", combination.Name, strings.Join(labelErrs, ", ")), fmt.Sprintf("matrix.combinations[%d].name", i)))
+		}
+		if seen.Has(combination.Name) {
+			errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("duplicate combination name %q", combination.Name), fmt.Sprintf("matrix.combinations[%d].name", i)))
+		}
+		seen.Insert(combination.Name)
+	}
+	return errs
+}
+
 // validateParams validates the type of Parameter for Matrix.Params and Matrix.Include.Params
 // Matrix.Params must be of type array. Matrix.Include.Params must be of type string.
 // validateParams also validates Matrix.Params for a unique list of params
@@ -245,6 +442,22 @@ func (m *Matrix) validateParams() (errs *apis.FieldError) {
 				}
 			}
 		}
+		if m.HasExclude() {
+			matrixParamNames := m.Params.ExtractNames()
+			for i, exclude := range m.Exclude {
+				if len(exclude.Params) == 0 {
+					errs = errs.Also(apis.ErrInvalidValue("matrix.exclude entries must specify at least one param, an empty entry matches (and excludes) every combination", fmt.Sprintf("matrix.exclude[%d].params", i)))
+				}
+				for _, param := range exclude.Params {
+					if param.Value.Type != ParamTypeString {
+						errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("parameters of type string only are allowed, but got param type %s", string(param.Value.Type)), "").ViaFieldKey(fmt.Sprintf("matrix.exclude[%d].params", i), param.Name))
+					}
+					if !matrixParamNames.Has(param.Name) {
+						errs = errs.Also(apis.ErrInvalidValue(fmt.Sprintf("unknown parameter name %q, does not match any Matrix.Params name", param.Name), "").ViaFieldKey(fmt.Sprintf("matrix.exclude[%d].params", i), param.Name))
+					}
+				}
+			}
+		}
 	}
 	return errs
 }
@@ -264,6 +477,11 @@ func (m *Matrix) validatePipelineParametersVariablesInMatrixParameters(prefix st
 	if m.HasParams() {
 		for _, param := range m.Params {
 			for idx, arrayElement := range param.Value.ArrayVal {
+				// A reference to a previous PipelineTask's results is resolved at runtime, once that
+				// PipelineTask completes, rather than validated as a pipeline parameter variable here.
+				if resultRefPattern.MatchString(arrayElement) {
+					continue
+				}
 				// Matrix Params must be of type array
 				errs = errs.Also(validateArrayVariable(arrayElement, prefix, paramNames, arrayParamNames, objectParamNameKeys).ViaFieldIndex("value", idx).ViaFieldKey("matrix.params", param.Name))
 			}
@@ -0,0 +1,381 @@
+/*
+Copyright 2023 The Tekton Authors
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func stringParam(name string, values ...string) Param {
+	return Param{Name: name, Value: ParamValue{Type: ParamTypeArray, ArrayVal: values}}
+}
+
+func stringIncludeParam(name, value string) Param {
+	return Param{Name: name, Value: ParamValue{Type: ParamTypeString, StringVal: value}}
+}
+
+func TestMatrix_FanOut_Exclude(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix Matrix
+		want   []Params
+	}{{
+		name: "no excludes",
+		matrix: Matrix{
+			Params: Params{stringParam("os", "linux", "mac")},
+		},
+		want: []Params{
+			{stringIncludeParam("os", "linux")},
+			{stringIncludeParam("os", "mac")},
+		},
+	}, {
+		name: "exclude a single combination",
+		matrix: Matrix{
+			Params: Params{
+				stringParam("os", "linux", "mac"),
+				stringParam("version", "go118", "go119"),
+			},
+			Exclude: ExcludeParamsList{{
+				Params: Params{stringIncludeParam("os", "mac"), stringIncludeParam("version", "go118")},
+			}},
+		},
+		want: []Params{
+			{stringIncludeParam("os", "linux"), stringIncludeParam("version", "go118")},
+			{stringIncludeParam("os", "linux"), stringIncludeParam("version", "go119")},
+			{stringIncludeParam("os", "mac"), stringIncludeParam("version", "go119")},
+		},
+	}, {
+		name: "exclude referencing a single param excludes every matching combination",
+		matrix: Matrix{
+			Params: Params{
+				stringParam("os", "linux", "mac"),
+				stringParam("version", "go118", "go119"),
+			},
+			Exclude: ExcludeParamsList{{
+				Params: Params{stringIncludeParam("os", "mac")},
+			}},
+		},
+		want: []Params{
+			{stringIncludeParam("os", "linux"), stringIncludeParam("version", "go118")},
+			{stringIncludeParam("os", "linux"), stringIncludeParam("version", "go119")},
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.matrix.FanOut()
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Errorf("Matrix.FanOut() diff (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+func TestMatrix_CountCombinations_Exclude(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix Matrix
+		want   int
+	}{{
+		name: "exclude reduces the combinations count",
+		matrix: Matrix{
+			Params: Params{
+				stringParam("os", "linux", "mac"),
+				stringParam("version", "go118", "go119"),
+			},
+			Exclude: ExcludeParamsList{{
+				Params: Params{stringIncludeParam("os", "mac"), stringIncludeParam("version", "go118")},
+			}},
+		},
+		want: 3,
+	}, {
+		name: "exclude and include both apply",
+		matrix: Matrix{
+			Params: Params{stringParam("os", "linux", "mac")},
+			Exclude: ExcludeParamsList{{
+				Params: Params{stringIncludeParam("os", "mac")},
+			}},
+			Include: IncludeParamsList{{
+				Name:   "windows-build",
+				Params: Params{stringIncludeParam("os", "windows")},
+			}},
+		},
+		want: 2,
+	}, {
+		name: "include value coincides with an excluded value",
+		matrix: Matrix{
+			Params: Params{stringParam("os", "mac")},
+			Exclude: ExcludeParamsList{{
+				Params: Params{stringIncludeParam("os", "mac")},
+			}},
+			Include: IncludeParamsList{{
+				Name:   "mac-again",
+				Params: Params{stringIncludeParam("os", "mac")},
+			}},
+		},
+		want: 1,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matrix.CountCombinations(); got != tt.want {
+				t.Errorf("Matrix.CountCombinations() = %d, want %d", got, tt.want)
+			}
+			if got := len(tt.matrix.FanOut()); got != tt.want {
+				t.Errorf("len(Matrix.FanOut()) = %d, want %d to match CountCombinations()", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatrix_FanOutNamed(t *testing.T) {
+	matrix := Matrix{
+		Params: Params{stringParam("os", "linux", "mac")},
+		Include: IncludeParamsList{{
+			Name:   "windows-build",
+			Params: Params{stringIncludeParam("os", "windows")},
+		}},
+		NameTemplate: "{{.os}}-build",
+	}
+	want := []NamedCombination{
+		{Name: "linux-build", Params: Params{stringIncludeParam("os", "linux")}},
+		{Name: "mac-build", Params: Params{stringIncludeParam("os", "mac")}},
+		{Name: "windows-build", Params: Params{stringIncludeParam("os", "windows")}},
+	}
+	got, err := matrix.FanOutNamed()
+	if err != nil {
+		t.Fatalf("Matrix.FanOutNamed() returned unexpected error: %v", err)
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("Matrix.FanOutNamed() diff (-want +got):\n%s", d)
+	}
+}
+
+func TestMatrix_FanOutNamed_InvalidTemplate(t *testing.T) {
+	matrix := Matrix{
+		Params:       Params{stringParam("os", "linux")},
+		NameTemplate: "{{.os",
+	}
+	if _, err := matrix.FanOutNamed(); err == nil {
+		t.Error("Matrix.FanOutNamed() expected an error for an invalid NameTemplate, got none")
+	}
+}
+
+func TestMatrix_validateCombinationNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		matrix  Matrix
+		wantErr bool
+	}{{
+		name: "unique, DNS-1123-safe names",
+		matrix: Matrix{
+			Params:       Params{stringParam("os", "linux", "mac")},
+			NameTemplate: "{{.os}}-build",
+		},
+		wantErr: false,
+	}, {
+		name: "duplicate names",
+		matrix: Matrix{
+			Params:       Params{stringParam("os", "linux", "mac")},
+			NameTemplate: "build",
+		},
+		wantErr: true,
+	}, {
+		name: "name is not a valid DNS-1123 label",
+		matrix: Matrix{
+			Params:       Params{stringParam("os", "linux")},
+			NameTemplate: "{{.os}}_build",
+		},
+		wantErr: true,
+	}, {
+		name: "NameTemplate combined with a result ref is deferred, not rejected",
+		matrix: Matrix{
+			Params:       Params{stringParam("shard", "$(tasks.list-shards.results.shards[*])")},
+			NameTemplate: "{{.shard}}-build",
+		},
+		wantErr: false,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.matrix.validateCombinationNames()
+			if (errs != nil) != tt.wantErr {
+				t.Errorf("validateCombinationNames() errs = %v, wantErr %t", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatrix_validateCombinationsCount(t *testing.T) {
+	// 257 values, one more than config.DefaultMaxMatrixCombinationsCount.
+	values := make([]string, 257)
+	for i := range values {
+		values[i] = fmt.Sprintf("v%d", i)
+	}
+	tests := []struct {
+		name    string
+		matrix  Matrix
+		wantErr bool
+	}{{
+		name:    "a large matrix without result refs is still checked against the cap",
+		matrix:  Matrix{Params: Params{stringParam("v", values...)}},
+		wantErr: true,
+	}, {
+		name:    "a matrix with result refs is not checked against the cap, since the literal count is meaningless",
+		matrix:  Matrix{Params: Params{stringParam("shard", "$(tasks.list-shards.results.shards[*])")}},
+		wantErr: false,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.matrix.validateCombinationsCount(context.Background())
+			if (errs != nil) != tt.wantErr {
+				t.Errorf("validateCombinationsCount() errs = %v, wantErr %t", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatrix_FanOutIter(t *testing.T) {
+	matrix := Matrix{
+		Params: Params{
+			stringParam("os", "linux", "mac"),
+			stringParam("version", "go118", "go119"),
+		},
+		Include: IncludeParamsList{{
+			Name:   "windows-build",
+			Params: Params{stringIncludeParam("os", "windows")},
+		}},
+	}
+	var got []Params
+	if err := matrix.FanOutIter(func(params Params) error {
+		got = append(got, params)
+		return nil
+	}); err != nil {
+		t.Fatalf("Matrix.FanOutIter() returned unexpected error: %v", err)
+	}
+	want := []Params{
+		{stringIncludeParam("os", "linux"), stringIncludeParam("version", "go118")},
+		{stringIncludeParam("os", "mac"), stringIncludeParam("version", "go118")},
+		{stringIncludeParam("os", "linux"), stringIncludeParam("version", "go119")},
+		{stringIncludeParam("os", "mac"), stringIncludeParam("version", "go119")},
+		{stringIncludeParam("os", "windows")},
+	}
+	if d := cmp.Diff(want, got); d != "" {
+		t.Errorf("Matrix.FanOutIter() diff (-want +got):\n%s", d)
+	}
+	if d := cmp.Diff(got, matrix.FanOut()); d != "" {
+		t.Errorf("Matrix.FanOut() should match Matrix.FanOutIter()'s emitted combinations, diff (-FanOutIter +FanOut):\n%s", d)
+	}
+}
+
+func TestMatrix_FanOutIter_StopsOnError(t *testing.T) {
+	matrix := Matrix{Params: Params{stringParam("os", "linux", "mac", "windows")}}
+	wantErr := fmt.Errorf("stop")
+	calls := 0
+	err := matrix.FanOutIter(func(Params) error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Errorf("Matrix.FanOutIter() = %v, want %v", err, wantErr)
+	}
+	if calls != 1 {
+		t.Errorf("Matrix.FanOutIter() called f %d times, want 1 (stop on first error)", calls)
+	}
+}
+
+func TestMatrix_HasResultRefs(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix Matrix
+		want   bool
+	}{{
+		name:   "no params",
+		matrix: Matrix{},
+		want:   false,
+	}, {
+		name:   "static values only",
+		matrix: Matrix{Params: Params{stringParam("os", "linux", "mac")}},
+		want:   false,
+	}, {
+		name:   "references a whole-array result",
+		matrix: Matrix{Params: Params{stringParam("shard", "$(tasks.list-shards.results.shards[*])")}},
+		want:   true,
+	}, {
+		name:   "references an indexed result element",
+		matrix: Matrix{Params: Params{stringParam("shard", "$(tasks.list-shards.results.shards[0])")}},
+		want:   true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matrix.HasResultRefs(); got != tt.want {
+				t.Errorf("Matrix.HasResultRefs() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatrix_validatePipelineParametersVariablesInMatrixParameters_ResultRefs(t *testing.T) {
+	matrix := Matrix{Params: Params{stringParam("shard", "$(tasks.list-shards.results.shards[*])")}}
+	errs := matrix.validatePipelineParametersVariablesInMatrixParameters("pipelinetask", sets.NewString(), sets.NewString(), map[string][]string{})
+	if errs != nil {
+		t.Errorf("validatePipelineParametersVariablesInMatrixParameters() = %v, want no errors for a result reference", errs)
+	}
+}
+
+func TestMatrix_validateParams_Exclude(t *testing.T) {
+	tests := []struct {
+		name    string
+		matrix  Matrix
+		wantErr bool
+	}{{
+		name: "valid exclude referencing known matrix params",
+		matrix: Matrix{
+			Params:  Params{stringParam("os", "linux", "mac")},
+			Exclude: ExcludeParamsList{{Params: Params{stringIncludeParam("os", "mac")}}},
+		},
+		wantErr: false,
+	}, {
+		name: "exclude referencing an unknown matrix param",
+		matrix: Matrix{
+			Params:  Params{stringParam("os", "linux", "mac")},
+			Exclude: ExcludeParamsList{{Params: Params{stringIncludeParam("arch", "arm64")}}},
+		},
+		wantErr: true,
+	}, {
+		name: "exclude param of type array instead of string",
+		matrix: Matrix{
+			Params:  Params{stringParam("os", "linux", "mac")},
+			Exclude: ExcludeParamsList{{Params: Params{stringParam("os", "mac")}}},
+		},
+		wantErr: true,
+	}, {
+		name: "empty exclude entry would vacuously match and exclude every combination",
+		matrix: Matrix{
+			Params:  Params{stringParam("os", "linux", "mac")},
+			Exclude: ExcludeParamsList{{}},
+		},
+		wantErr: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := tt.matrix.validateParams()
+			if (errs != nil) != tt.wantErr {
+				t.Errorf("validateParams() errs = %v, wantErr %t", errs, tt.wantErr)
+			}
+		})
+	}
+}